@@ -0,0 +1,147 @@
+package kubeauth
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	configPath = "config"
+	rolePrefix = "role/"
+)
+
+// kubeAuthBackend implements the Backend interface for the kubernetes auth
+// method plugin.
+type kubeAuthBackend struct {
+	*framework.Backend
+
+	// l protects reads and writes to the backend's config and roles, and is
+	// held while validating a login so that config/role changes can't race
+	// with an in-flight request.
+	l sync.RWMutex
+
+	// reviewFactory is used to create tokenReviewer instances and can be
+	// overridden for testing.
+	reviewFactory tokenReviewFactory
+
+	// saGetterFactory is used to create serviceAccountGetter instances and
+	// can be overridden for testing.
+	saGetterFactory serviceAccountGetterFactory
+
+	// jwksCache holds the most recently discovered JWKS key set, used when
+	// config.UseJWKSDiscovery is enabled. It has its own mutex, separate
+	// from l, since it's refreshed from inside pathLogin while l.RLock()
+	// is already held for the duration of the request.
+	jwksCache *jwksCache
+
+	// saAnnotations caches ServiceAccount annotations fetched for
+	// annotation-driven alias names and bindings.
+	saAnnotations *saAnnotationsCache
+}
+
+// Factory returns a new backend as logical.Backend.
+func Factory(ctx context.Context, conf *logical.BackendConfig) (logical.Backend, error) {
+	b, err := Backend(conf)
+	if err != nil {
+		return nil, err
+	}
+	if err := b.Setup(ctx, conf); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Backend returns a configured kubeAuthBackend ready for Setup.
+func Backend(conf *logical.BackendConfig) (*kubeAuthBackend, error) {
+	b := &kubeAuthBackend{
+		reviewFactory:   tokenReviewAPIFactory,
+		saGetterFactory: serviceAccountGetterAPIFactory,
+		jwksCache:       &jwksCache{},
+		saAnnotations: &saAnnotationsCache{
+			entries: make(map[string]saAnnotationsCacheEntry),
+		},
+	}
+
+	b.Backend = &framework.Backend{
+		Help:      backendHelp,
+		AuthRenew: b.pathLoginRenew(),
+		PathsSpecial: &logical.Paths{
+			Unauthenticated: []string{
+				"login",
+			},
+		},
+		Paths: []*framework.Path{
+			pathLogin(b),
+			pathConfig(b),
+			pathRole(b),
+			pathRoleList(b),
+		},
+		BackendType: logical.TypeCredential,
+	}
+
+	return b, nil
+}
+
+// role fetches and decodes a role from storage. A nil role is returned, with
+// no error, if the role doesn't exist.
+func (b *kubeAuthBackend) role(ctx context.Context, s logical.Storage, name string) (*roleStorageEntry, error) {
+	raw, err := s.Get(ctx, rolePrefix+strings.ToLower(name))
+	if err != nil {
+		return nil, err
+	}
+	if raw == nil {
+		return nil, nil
+	}
+
+	role := &roleStorageEntry{}
+	if err := raw.DecodeJSON(role); err != nil {
+		return nil, err
+	}
+
+	// PublicKeys is derived from PEMKeys and isn't itself persisted, so it
+	// has to be recomputed on every load.
+	publicKeys, err := parsePublicKeyPEMs(role.PEMKeys)
+	if err != nil {
+		return nil, err
+	}
+	role.PublicKeys = publicKeys
+
+	return role, nil
+}
+
+// loadConfig fetches and decodes the backend's configuration from storage. A
+// non-nil, zero-value config is returned if none has been written yet.
+func (b *kubeAuthBackend) loadConfig(ctx context.Context, s logical.Storage) (*kubeConfig, error) {
+	raw, err := s.Get(ctx, configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &kubeConfig{}
+	if raw == nil {
+		return config, nil
+	}
+
+	if err := raw.DecodeJSON(config); err != nil {
+		return nil, err
+	}
+
+	// PublicKeys is derived from PEMKeys and isn't itself persisted, so it
+	// has to be recomputed on every load.
+	publicKeys, err := parsePublicKeyPEMs(config.PEMKeys)
+	if err != nil {
+		return nil, err
+	}
+	config.PublicKeys = publicKeys
+
+	return config, nil
+}
+
+const backendHelp = `
+The Kubernetes Auth Backend allows authentication for Kubernetes service
+accounts.
+`
@@ -0,0 +1,39 @@
+package kubeauth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+)
+
+// parsePublicKeyPEM parses a PEM block containing either a certificate or a
+// raw public key and returns the contained public key.
+func parsePublicKeyPEM(data []byte) (interface{}, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("error decoding PEM block")
+	}
+
+	if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+		switch pub := cert.PublicKey.(type) {
+		case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+			return pub, nil
+		default:
+			return nil, errors.New("certificate does not contain an RSA, ECDSA, or Ed25519 public key")
+		}
+	}
+
+	if key, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+		switch pub := key.(type) {
+		case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+			return pub, nil
+		default:
+			return nil, errors.New("unsupported public key type in PEM block")
+		}
+	}
+
+	return nil, errors.New("data does not contain a valid RSA, ECDSA, or Ed25519 public key or certificate")
+}
@@ -0,0 +1,76 @@
+package kubeauth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+)
+
+func marshalPublicKeyPEM(t *testing.T, pub interface{}) []byte {
+	t.Helper()
+
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatalf("failed to marshal public key: %v", err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+func TestParsePublicKeyPEM(t *testing.T) {
+	t.Run("rsa", func(t *testing.T) {
+		priv, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatalf("failed to generate key: %v", err)
+		}
+
+		key, err := parsePublicKeyPEM(marshalPublicKeyPEM(t, &priv.PublicKey))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, ok := key.(*rsa.PublicKey); !ok {
+			t.Fatalf("expected *rsa.PublicKey, got %T", key)
+		}
+	})
+
+	t.Run("ecdsa", func(t *testing.T) {
+		priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatalf("failed to generate key: %v", err)
+		}
+
+		key, err := parsePublicKeyPEM(marshalPublicKeyPEM(t, &priv.PublicKey))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, ok := key.(*ecdsa.PublicKey); !ok {
+			t.Fatalf("expected *ecdsa.PublicKey, got %T", key)
+		}
+	})
+
+	t.Run("ed25519", func(t *testing.T) {
+		pub, _, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("failed to generate key: %v", err)
+		}
+
+		key, err := parsePublicKeyPEM(marshalPublicKeyPEM(t, pub))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, ok := key.(ed25519.PublicKey); !ok {
+			t.Fatalf("expected ed25519.PublicKey, got %T", key)
+		}
+	})
+
+	t.Run("invalid PEM", func(t *testing.T) {
+		if _, err := parsePublicKeyPEM([]byte("not a pem block")); err == nil {
+			t.Fatal("expected error for invalid PEM")
+		}
+	})
+}
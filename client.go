@@ -0,0 +1,256 @@
+package kubeauth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tokenReviewResult holds the fields of a TokenReview response that the
+// backend cares about.
+type tokenReviewResult struct {
+	Name      string
+	UID       string
+	Namespace string
+}
+
+// tokenReviewer submits a Kubernetes TokenReview request and returns the
+// parts of the result used to authenticate a service account.
+type tokenReviewer interface {
+	Review(ctx context.Context, jwt string, audiences []string) (*tokenReviewResult, error)
+}
+
+// tokenReviewFactory builds a tokenReviewer for the given config, so that
+// each login can review against the correct Kubernetes API server.
+type tokenReviewFactory func(config *kubeConfig) tokenReviewer
+
+// tokenReviewAPIClient implements tokenReviewer against a real Kubernetes API
+// server using the TokenReview API.
+type tokenReviewAPIClient struct {
+	config *kubeConfig
+	client *http.Client
+}
+
+// tokenReviewAPIFactory is the default tokenReviewFactory used by the
+// backend in production.
+func tokenReviewAPIFactory(config *kubeConfig) tokenReviewer {
+	return &tokenReviewAPIClient{
+		config: config,
+		client: &http.Client{},
+	}
+}
+
+// tokenReviewRequest is the subset of the authentication.k8s.io/v1
+// TokenReview request body that this backend sends.
+type tokenReviewRequest struct {
+	APIVersion string                 `json:"apiVersion"`
+	Kind       string                 `json:"kind"`
+	Spec       tokenReviewRequestSpec `json:"spec"`
+}
+
+type tokenReviewRequestSpec struct {
+	Token     string   `json:"token"`
+	Audiences []string `json:"audiences,omitempty"`
+}
+
+// tokenReviewResponse is the subset of the authentication.k8s.io/v1
+// TokenReview response body that this backend cares about.
+type tokenReviewResponse struct {
+	Status struct {
+		Authenticated bool   `json:"authenticated"`
+		Error         string `json:"error"`
+		User          struct {
+			Username string `json:"username"`
+			UID      string `json:"uid"`
+		} `json:"user"`
+	} `json:"status"`
+}
+
+// Review calls the TokenReview API at config.Host using the configured
+// reviewer JWT, and returns the reviewed identity.
+func (c *tokenReviewAPIClient) Review(ctx context.Context, jwt string, audiences []string) (*tokenReviewResult, error) {
+	if c.config.Host == "" {
+		return nil, errors.New("no Host configured for the kubernetes auth method")
+	}
+
+	url := fmt.Sprintf("%s/apis/authentication.k8s.io/v1/tokenreviews", strings.TrimRight(c.config.Host, "/"))
+
+	body, err := json.Marshal(&tokenReviewRequest{
+		APIVersion: "authentication.k8s.io/v1",
+		Kind:       "TokenReview",
+		Spec: tokenReviewRequestSpec{
+			Token:     jwt,
+			Audiences: audiences,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.config.TokenReviewerJWT != "" {
+		req.Header.Set("Authorization", "Bearer "+c.config.TokenReviewerJWT)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d reviewing token", resp.StatusCode)
+	}
+
+	var review tokenReviewResponse
+	if err := json.Unmarshal(respBody, &review); err != nil {
+		return nil, err
+	}
+	if !review.Status.Authenticated {
+		if review.Status.Error != "" {
+			return nil, fmt.Errorf("token review failed: %s", review.Status.Error)
+		}
+		return nil, errors.New("token review failed: token not authenticated")
+	}
+
+	// the username is of the form
+	// "system:serviceaccount:(NAMESPACE):(SERVICEACCOUNT)"
+	parts := strings.Split(review.Status.User.Username, ":")
+	if len(parts) != 4 || parts[0] != "system" || parts[1] != "serviceaccount" {
+		return nil, fmt.Errorf("unexpected username format from token review: %q", review.Status.User.Username)
+	}
+
+	return &tokenReviewResult{
+		Name:      parts[3],
+		UID:       review.Status.User.UID,
+		Namespace: parts[2],
+	}, nil
+}
+
+// serviceAccountGetter fetches a ServiceAccount object from the Kubernetes
+// API, used to read annotations that the TokenReview response doesn't carry.
+type serviceAccountGetter interface {
+	Get(ctx context.Context, namespace, name string) (map[string]string, error)
+}
+
+// serviceAccountGetterFactory builds a serviceAccountGetter for the given
+// config, mirroring tokenReviewFactory.
+type serviceAccountGetterFactory func(config *kubeConfig) serviceAccountGetter
+
+// serviceAccountGetterAPIClient implements serviceAccountGetter against a
+// real Kubernetes API server.
+type serviceAccountGetterAPIClient struct {
+	config *kubeConfig
+	client *http.Client
+}
+
+// serviceAccountGetterAPIFactory is the default serviceAccountGetterFactory
+// used by the backend in production.
+func serviceAccountGetterAPIFactory(config *kubeConfig) serviceAccountGetter {
+	return &serviceAccountGetterAPIClient{
+		config: config,
+		client: &http.Client{},
+	}
+}
+
+// Get fetches the named ServiceAccount from the Kubernetes API and returns
+// its annotations.
+func (c *serviceAccountGetterAPIClient) Get(ctx context.Context, namespace, name string) (map[string]string, error) {
+	if c.config.Host == "" {
+		return nil, errors.New("no Host configured for the kubernetes auth method")
+	}
+
+	url := fmt.Sprintf("%s/api/v1/namespaces/%s/serviceaccounts/%s", strings.TrimRight(c.config.Host, "/"), namespace, name)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.config.TokenReviewerJWT != "" {
+		req.Header.Set("Authorization", "Bearer "+c.config.TokenReviewerJWT)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d looking up service account %s/%s", resp.StatusCode, namespace, name)
+	}
+
+	var sa struct {
+		Metadata struct {
+			Annotations map[string]string `json:"annotations"`
+		} `json:"metadata"`
+	}
+	if err := json.Unmarshal(body, &sa); err != nil {
+		return nil, err
+	}
+
+	return sa.Metadata.Annotations, nil
+}
+
+// saAnnotationsCacheTTL bounds how long a fetched ServiceAccount's
+// annotations are reused across logins before being re-fetched.
+const saAnnotationsCacheTTL = 10 * time.Second
+
+// saAnnotationsCache is a short-lived, namespace/name-keyed cache of
+// ServiceAccount annotations, so that a burst of logins for the same
+// ServiceAccount doesn't hit the Kubernetes API once per login.
+type saAnnotationsCache struct {
+	mu      sync.Mutex
+	entries map[string]saAnnotationsCacheEntry
+}
+
+type saAnnotationsCacheEntry struct {
+	annotations map[string]string
+	fetchedAt   time.Time
+}
+
+// serviceAccountAnnotations returns the annotations on the given
+// ServiceAccount, serving a cached value if it's fresh enough.
+func (b *kubeAuthBackend) serviceAccountAnnotations(ctx context.Context, config *kubeConfig, namespace, name string) (map[string]string, error) {
+	key := namespace + "/" + name
+
+	b.saAnnotations.mu.Lock()
+	entry, ok := b.saAnnotations.entries[key]
+	b.saAnnotations.mu.Unlock()
+	if ok && time.Since(entry.fetchedAt) < saAnnotationsCacheTTL {
+		return entry.annotations, nil
+	}
+
+	annotations, err := b.saGetterFactory(config).Get(ctx, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+
+	b.saAnnotations.mu.Lock()
+	b.saAnnotations.entries[key] = saAnnotationsCacheEntry{
+		annotations: annotations,
+		fetchedAt:   time.Now(),
+	}
+	b.saAnnotations.mu.Unlock()
+
+	return annotations, nil
+}
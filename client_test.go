@@ -0,0 +1,136 @@
+package kubeauth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTokenReviewAPIClient_Review(t *testing.T) {
+	t.Run("authenticated", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				t.Fatalf("expected POST, got %s", r.Method)
+			}
+			if r.URL.Path != "/apis/authentication.k8s.io/v1/tokenreviews" {
+				t.Fatalf("unexpected path: %s", r.URL.Path)
+			}
+			if got := r.Header.Get("Authorization"); got != "Bearer reviewer-jwt" {
+				t.Fatalf("unexpected Authorization header: %q", got)
+			}
+
+			var reqBody tokenReviewRequest
+			if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+				t.Fatalf("failed to decode request body: %v", err)
+			}
+			if reqBody.Spec.Token != "sa-jwt" {
+				t.Fatalf("unexpected token in request: %q", reqBody.Spec.Token)
+			}
+
+			resp := tokenReviewResponse{}
+			resp.Status.Authenticated = true
+			resp.Status.User.Username = "system:serviceaccount:default:my-sa"
+			resp.Status.User.UID = "abc-123"
+
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(resp)
+		}))
+		defer srv.Close()
+
+		c := &tokenReviewAPIClient{
+			config: &kubeConfig{Host: srv.URL, TokenReviewerJWT: "reviewer-jwt"},
+			client: srv.Client(),
+		}
+
+		result, err := c.Review(context.Background(), "sa-jwt", []string{"vault"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if result.Name != "my-sa" || result.Namespace != "default" || result.UID != "abc-123" {
+			t.Fatalf("unexpected result: %+v", result)
+		}
+	})
+
+	t.Run("not authenticated", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			resp := tokenReviewResponse{}
+			resp.Status.Authenticated = false
+			resp.Status.Error = "token expired"
+			json.NewEncoder(w).Encode(resp)
+		}))
+		defer srv.Close()
+
+		c := &tokenReviewAPIClient{
+			config: &kubeConfig{Host: srv.URL},
+			client: srv.Client(),
+		}
+
+		if _, err := c.Review(context.Background(), "sa-jwt", nil); err == nil {
+			t.Fatal("expected error for unauthenticated token review")
+		}
+	})
+
+	t.Run("no host configured", func(t *testing.T) {
+		c := &tokenReviewAPIClient{config: &kubeConfig{}}
+		if _, err := c.Review(context.Background(), "sa-jwt", nil); err == nil {
+			t.Fatal("expected error when Host is unset")
+		}
+	})
+}
+
+func TestServiceAccountGetterAPIClient_Get(t *testing.T) {
+	t.Run("returns annotations", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet {
+				t.Fatalf("expected GET, got %s", r.Method)
+			}
+			if r.URL.Path != "/api/v1/namespaces/default/serviceaccounts/my-sa" {
+				t.Fatalf("unexpected path: %s", r.URL.Path)
+			}
+			if got := r.Header.Get("Authorization"); got != "Bearer reviewer-jwt" {
+				t.Fatalf("unexpected Authorization header: %q", got)
+			}
+
+			w.Write([]byte(`{"metadata":{"annotations":{"team":"platform"}}}`))
+		}))
+		defer srv.Close()
+
+		c := &serviceAccountGetterAPIClient{
+			config: &kubeConfig{Host: srv.URL, TokenReviewerJWT: "reviewer-jwt"},
+			client: srv.Client(),
+		}
+
+		annotations, err := c.Get(context.Background(), "default", "my-sa")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if annotations["team"] != "platform" {
+			t.Fatalf("unexpected annotations: %+v", annotations)
+		}
+	})
+
+	t.Run("non-200 status is an error", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		}))
+		defer srv.Close()
+
+		c := &serviceAccountGetterAPIClient{
+			config: &kubeConfig{Host: srv.URL},
+			client: srv.Client(),
+		}
+
+		if _, err := c.Get(context.Background(), "default", "my-sa"); err == nil {
+			t.Fatal("expected error for non-200 status")
+		}
+	})
+
+	t.Run("no host configured", func(t *testing.T) {
+		c := &serviceAccountGetterAPIClient{config: &kubeConfig{}}
+		if _, err := c.Get(context.Background(), "default", "my-sa"); err == nil {
+			t.Fatal("expected error when Host is unset")
+		}
+	})
+}
@@ -0,0 +1,320 @@
+package kubeauth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// defaultJWKSRefreshInterval is used when use_jwks_discovery is enabled but
+// jwks_refresh_interval is unset.
+const defaultJWKSRefreshInterval = 1 * time.Hour
+
+// jwksKeySet is the cached result of a JWKS discovery fetch, keyed by `kid`
+// so that a kid-miss during login can trigger a targeted refresh.
+type jwksKeySet struct {
+	keys      []interface{}
+	kids      map[string]interface{}
+	fetchedAt time.Time
+}
+
+// jwksCache guards jwksKeySet with its own mutex, rather than b.l, since
+// jwksPublicKeys is called while pathLogin already holds b.l.RLock() for the
+// duration of the request; reusing b.l here would deadlock on the write
+// lock taken to refresh the cache.
+type jwksCache struct {
+	mu  sync.RWMutex
+	set *jwksKeySet
+}
+
+// jwksPublicKeys returns the set of public keys discovered via JWKS,
+// refreshing the cache if it's stale or missing the JWT's kid.
+func (b *kubeAuthBackend) jwksPublicKeys(ctx context.Context, config *kubeConfig, kid string) ([]interface{}, error) {
+	b.jwksCache.mu.RLock()
+	cache := b.jwksCache.set
+	b.jwksCache.mu.RUnlock()
+
+	if cache != nil && !jwksNeedsRefresh(cache, config, kid) {
+		return cache.keys, nil
+	}
+
+	fresh, err := b.fetchJWKS(ctx, config)
+	if err != nil {
+		if cache != nil {
+			// Serve the stale cache rather than fail logins outright on a
+			// transient discovery error.
+			return cache.keys, nil
+		}
+		return nil, err
+	}
+
+	b.jwksCache.mu.Lock()
+	b.jwksCache.set = fresh
+	b.jwksCache.mu.Unlock()
+
+	return fresh.keys, nil
+}
+
+func jwksNeedsRefresh(cache *jwksKeySet, config *kubeConfig, kid string) bool {
+	interval := config.JWKSRefreshInterval
+	if interval <= 0 {
+		interval = defaultJWKSRefreshInterval
+	}
+	if time.Since(cache.fetchedAt) > interval {
+		return true
+	}
+	if kid != "" {
+		if _, ok := cache.kids[kid]; !ok {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchJWKS discovers and fetches the cluster's signing key set from the
+// Kubernetes API server's OIDC discovery endpoint, or from config.JWKSURL
+// if set, using the plugin's configured Kubernetes CA and bearer token.
+func (b *kubeAuthBackend) fetchJWKS(ctx context.Context, config *kubeConfig) (*jwksKeySet, error) {
+	client, err := jwksHTTPClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	jwksURL := config.JWKSURL
+	if jwksURL == "" {
+		jwksURL, err = discoverJWKSURL(ctx, client, config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to discover jwks_uri: %w", err)
+		}
+	}
+
+	body, err := doJWKSRequest(ctx, client, config, jwksURL)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, kids, err := parseJWKS(body)
+	if err != nil {
+		return nil, err
+	}
+
+	return &jwksKeySet{
+		keys:      keys,
+		kids:      kids,
+		fetchedAt: time.Now(),
+	}, nil
+}
+
+type oidcDiscoveryDoc struct {
+	JWKSURI string `json:"jwks_uri"`
+}
+
+// discoverJWKSURL fetches the OIDC discovery document from the Kubernetes
+// API server and returns its advertised jwks_uri.
+func discoverJWKSURL(ctx context.Context, client *http.Client, config *kubeConfig) (string, error) {
+	discoveryURL := strings.TrimRight(config.Host, "/") + "/.well-known/openid-configuration"
+
+	body, err := doJWKSRequest(ctx, client, config, discoveryURL)
+	if err != nil {
+		return "", err
+	}
+
+	var doc oidcDiscoveryDoc
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return "", err
+	}
+	if doc.JWKSURI == "" {
+		return "", errors.New("discovery document did not contain a jwks_uri")
+	}
+
+	return doc.JWKSURI, nil
+}
+
+func doJWKSRequest(ctx context.Context, client *http.Client, config *kubeConfig, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if config.TokenReviewerJWT != "" {
+		req.Header.Set("Authorization", "Bearer "+config.TokenReviewerJWT)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d from %s", resp.StatusCode, url)
+	}
+
+	return body, nil
+}
+
+func jwksHTTPClient(config *kubeConfig) (*http.Client, error) {
+	caCert := config.JWKSCACert
+	if caCert == "" {
+		caCert = config.CACert
+	}
+	if caCert == "" {
+		return &http.Client{}, nil
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM([]byte(caCert)) {
+		return nil, errors.New("failed to parse jwks_ca_cert")
+	}
+
+	return &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				RootCAs: pool,
+			},
+		},
+	}, nil
+}
+
+// jsonWebKeySet and jsonWebKey are minimal JWK Set decoders, just enough to
+// recover the RSA, ECDSA and Ed25519 public keys served by the Kubernetes API server's
+// /openid/v1/jwks endpoint.
+type jsonWebKeySet struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func parseJWKS(body []byte) ([]interface{}, map[string]interface{}, error) {
+	var set jsonWebKeySet
+	if err := json.Unmarshal(body, &set); err != nil {
+		return nil, nil, err
+	}
+
+	keys := make([]interface{}, 0, len(set.Keys))
+	kids := make(map[string]interface{}, len(set.Keys))
+
+	for _, k := range set.Keys {
+		key, err := k.publicKey()
+		if err != nil {
+			// Skip keys we don't understand (e.g. an unsupported curve)
+			// rather than failing the whole set.
+			continue
+		}
+		keys = append(keys, key)
+		if k.Kid != "" {
+			kids[k.Kid] = key
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, nil, errors.New("no usable RSA, ECDSA, or Ed25519 keys found in JWKS response")
+	}
+
+	return keys, kids, nil
+}
+
+func (k jsonWebKey) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64URLDecode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64URLDecode(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case "EC":
+		curve, err := ecdsaCurve(k.Crv)
+		if err != nil {
+			return nil, err
+		}
+		x, err := base64URLDecode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64URLDecode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported OKP curve %q", k.Crv)
+		}
+		x, err := base64URLDecode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported JWK key type %q", k.Kty)
+	}
+}
+
+func ecdsaCurve(crv string) (elliptic.Curve, error) {
+	switch crv {
+	case "P-256":
+		return elliptic.P256(), nil
+	case "P-384":
+		return elliptic.P384(), nil
+	case "P-521":
+		return elliptic.P521(), nil
+	default:
+		return nil, fmt.Errorf("unsupported EC curve %q", crv)
+	}
+}
+
+func base64URLDecode(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(s)
+}
+
+// jwtKid extracts the `kid` header from a signed JWT, if present.
+func jwtKid(jwtStr string) (string, error) {
+	parsedJWT, err := jwt.ParseSigned(jwtStr)
+	if err != nil {
+		return "", err
+	}
+
+	if len(parsedJWT.Headers) == 0 {
+		return "", nil
+	}
+
+	return parsedJWT.Headers[0].KeyID, nil
+}
@@ -0,0 +1,127 @@
+package kubeauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func jwksServer(t *testing.T, key *rsa.PublicKey, kid string) *httptest.Server {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"jwks_uri": "http://" + r.Host + "/openid/v1/jwks",
+		})
+	})
+	mux.HandleFunc("/openid/v1/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jsonWebKeySet{
+			Keys: []jsonWebKey{
+				{
+					Kty: "RSA",
+					Kid: kid,
+					N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+					E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+				},
+			},
+		})
+	})
+
+	return httptest.NewServer(mux)
+}
+
+func TestJwksPublicKeys(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	srv := jwksServer(t, &priv.PublicKey, "key-1")
+	defer srv.Close()
+
+	b := &kubeAuthBackend{jwksCache: &jwksCache{}}
+	config := &kubeConfig{Host: srv.URL, UseJWKSDiscovery: true}
+
+	keys, err := b.jwksPublicKeys(context.Background(), config, "key-1")
+	if err != nil {
+		t.Fatalf("unexpected error on cold cache: %v", err)
+	}
+	if len(keys) != 1 {
+		t.Fatalf("expected 1 key, got %d", len(keys))
+	}
+
+	// A second call with the same kid should be served from cache without
+	// needing the fake server again. The server is closed first; since a
+	// failed refetch also falls back to the cached keys, this shows the
+	// cached keys are returned, not that no refetch was attempted.
+	srv.Close()
+	keys, err = b.jwksPublicKeys(context.Background(), config, "key-1")
+	if err != nil {
+		t.Fatalf("unexpected error serving from cache: %v", err)
+	}
+	if len(keys) != 1 {
+		t.Fatalf("expected 1 key from cache, got %d", len(keys))
+	}
+}
+
+func TestJwksNeedsRefresh(t *testing.T) {
+	cache := &jwksKeySet{
+		fetchedAt: time.Now(),
+		kids:      map[string]interface{}{"known": struct{}{}},
+	}
+	config := &kubeConfig{JWKSRefreshInterval: time.Hour}
+
+	if jwksNeedsRefresh(cache, config, "known") {
+		t.Fatal("expected no refresh needed for fresh cache with known kid")
+	}
+	if !jwksNeedsRefresh(cache, config, "unknown") {
+		t.Fatal("expected refresh needed on kid miss")
+	}
+
+	stale := &jwksKeySet{fetchedAt: time.Now().Add(-2 * time.Hour)}
+	if !jwksNeedsRefresh(stale, config, "") {
+		t.Fatal("expected refresh needed once the interval has elapsed")
+	}
+}
+
+// TestJwksPublicKeys_NoDeadlockWithCallerHoldingBackendLock guards against a
+// regression where jwksPublicKeys took b.l.Lock() internally, which
+// deadlocks when called (as pathLogin does) while the caller already holds
+// b.l.RLock() for the duration of the request.
+func TestJwksPublicKeys_NoDeadlockWithCallerHoldingBackendLock(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	srv := jwksServer(t, &priv.PublicKey, "key-1")
+	defer srv.Close()
+
+	b := &kubeAuthBackend{jwksCache: &jwksCache{}}
+	config := &kubeConfig{Host: srv.URL, UseJWKSDiscovery: true}
+
+	b.l.RLock()
+	defer b.l.RUnlock()
+
+	done := make(chan struct{})
+	go func() {
+		if _, err := b.jwksPublicKeys(context.Background(), config, "key-1"); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("jwksPublicKeys deadlocked while caller held b.l.RLock()")
+	}
+}
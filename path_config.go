@@ -0,0 +1,236 @@
+package kubeauth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// kubeConfig holds the configuration for the kubernetes auth backend.
+type kubeConfig struct {
+	// Host is the host of the Kubernetes API server this backend validates
+	// tokens against.
+	Host string `json:"host"`
+
+	// CACert is the CA cert used to call the Kubernetes API server's OIDC
+	// discovery and JWKS endpoints, unless JWKSCACert is set. The
+	// TokenReview and ServiceAccount clients do not use it; they verify
+	// the API server against the system's root CAs.
+	CACert string `json:"kubernetes_ca_cert"`
+
+	// TokenReviewerJWT is the bearer token used to access the TokenReview
+	// API. If not set, requests are sent without an Authorization header;
+	// no local service account token is read.
+	TokenReviewerJWT string `json:"token_reviewer_jwt"`
+
+	// PEMKeys is the list of PEM-encoded certificates/public keys used to
+	// verify the signature on the service account JWTs.
+	PEMKeys []string `json:"pem_keys"`
+
+	// PublicKeys is the parsed form of PEMKeys, containing *rsa.PublicKey,
+	// *ecdsa.PublicKey, or ed25519.PublicKey values.
+	PublicKeys []interface{} `json:"-"`
+
+	// Issuer, if set, overrides the expected `iss` claim used during JWT
+	// validation.
+	Issuer string `json:"issuer"`
+
+	// DisableISSValidation disables validating the `iss` claim entirely.
+	DisableISSValidation bool `json:"disable_iss_validation"`
+
+	// DisableLocalCAJWT is stored and returned on read. The backend never
+	// loads a local service account CA certificate or JWT, so the flag has
+	// no effect on login.
+	DisableLocalCAJWT bool `json:"disable_local_ca_jwt"`
+
+	// UseJWKSDiscovery enables fetching the cluster's signing keys from the
+	// API server's OIDC discovery endpoint instead of using PEMKeys; while
+	// it is enabled, PEMKeys is not used to verify signatures. This keeps
+	// validation working across SA signing key rotation.
+	UseJWKSDiscovery bool `json:"use_jwks_discovery"`
+
+	// JWKSURL, if set, overrides the jwks_uri discovered from the API
+	// server's /.well-known/openid-configuration document.
+	JWKSURL string `json:"jwks_url"`
+
+	// JWKSCACert is the CA cert used to call the JWKS endpoint, if it
+	// differs from CACert.
+	JWKSCACert string `json:"jwks_ca_cert"`
+
+	// JWKSRefreshInterval controls how long the discovered key set is
+	// reused. It is refetched on the next login after the interval has
+	// elapsed (or on a kid-miss); there is no background refresh.
+	JWKSRefreshInterval time.Duration `json:"jwks_refresh_interval"`
+}
+
+func pathConfig(b *kubeAuthBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "config$",
+		Fields: map[string]*framework.FieldSchema{
+			"kubernetes_host": {
+				Type:        framework.TypeString,
+				Description: "Host must be a host string, a host:port pair, or a URL to the base of the Kubernetes API server.",
+			},
+			"kubernetes_ca_cert": {
+				Type:        framework.TypeString,
+				Description: "PEM encoded CA cert for use by the TLS client used to talk with the Kubernetes API's OIDC discovery and JWKS endpoints, unless jwks_ca_cert is set. TokenReview and ServiceAccount requests do not use it.",
+			},
+			"token_reviewer_jwt": {
+				Type:        framework.TypeString,
+				Description: "A service account JWT used to access the TokenReview API to validate other JWTs during login.",
+			},
+			"pem_keys": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Optional list of PEM-formatted public keys or certificates used to verify the signatures of Kubernetes service account JWTs.",
+			},
+			"issuer": {
+				Type:        framework.TypeString,
+				Description: "Optional JWT issuer. If no issuer is specified, `kubernetes/serviceaccount` is used.",
+			},
+			"disable_iss_validation": {
+				Type:        framework.TypeBool,
+				Description: "Disable JWT issuer validation. Allows to skip ISS validation.",
+			},
+			"disable_local_ca_jwt": {
+				Type:        framework.TypeBool,
+				Description: "Stored and returned on read. The backend does not load a local CA cert or service account JWT, so this setting has no effect on login.",
+			},
+			"use_jwks_discovery": {
+				Type:        framework.TypeBool,
+				Description: "Fetch the cluster's signing keys from the Kubernetes API server's OIDC discovery endpoint instead of relying on pem_keys. Recommended for clusters that rotate service account signing keys.",
+			},
+			"jwks_url": {
+				Type:        framework.TypeString,
+				Description: "Optional override for the discovered jwks_uri. If unset, it's discovered from kubernetes_host's /.well-known/openid-configuration document.",
+			},
+			"jwks_ca_cert": {
+				Type:        framework.TypeString,
+				Description: "PEM encoded CA cert for use by the TLS client used to talk with the JWKS endpoint, if different from kubernetes_ca_cert.",
+			},
+			"jwks_refresh_interval": {
+				Type:        framework.TypeDurationSecond,
+				Default:     int64(defaultJWKSRefreshInterval.Seconds()),
+				Description: "How often to refresh the discovered JWKS key set.",
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.CreateOperation: b.pathConfigWrite,
+			logical.UpdateOperation: b.pathConfigWrite,
+			logical.ReadOperation:   b.pathConfigRead,
+		},
+
+		HelpSynopsis:    "Configure the Kubernetes auth backend.",
+		HelpDescription: "Configure the Kubernetes auth backend's settings used to validate login requests.",
+	}
+}
+
+func (b *kubeAuthBackend) pathConfigWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	b.l.Lock()
+	defer b.l.Unlock()
+
+	config, err := b.loadConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if host, ok := data.GetOk("kubernetes_host"); ok {
+		config.Host = host.(string)
+	}
+	if caCert, ok := data.GetOk("kubernetes_ca_cert"); ok {
+		config.CACert = caCert.(string)
+	}
+	if jwt, ok := data.GetOk("token_reviewer_jwt"); ok {
+		config.TokenReviewerJWT = jwt.(string)
+	}
+	if issuer, ok := data.GetOk("issuer"); ok {
+		config.Issuer = issuer.(string)
+	}
+	if disableISS, ok := data.GetOk("disable_iss_validation"); ok {
+		config.DisableISSValidation = disableISS.(bool)
+	}
+	if disableLocalJWT, ok := data.GetOk("disable_local_ca_jwt"); ok {
+		config.DisableLocalCAJWT = disableLocalJWT.(bool)
+	}
+	if pemKeys, ok := data.GetOk("pem_keys"); ok {
+		config.PEMKeys = pemKeys.([]string)
+	}
+	if useJWKS, ok := data.GetOk("use_jwks_discovery"); ok {
+		config.UseJWKSDiscovery = useJWKS.(bool)
+	}
+	if jwksURL, ok := data.GetOk("jwks_url"); ok {
+		config.JWKSURL = jwksURL.(string)
+	}
+	if jwksCACert, ok := data.GetOk("jwks_ca_cert"); ok {
+		config.JWKSCACert = jwksCACert.(string)
+	}
+	if refresh, ok := data.GetOk("jwks_refresh_interval"); ok {
+		config.JWKSRefreshInterval = time.Duration(refresh.(int)) * time.Second
+	}
+
+	publicKeys, err := parsePublicKeyPEMs(config.PEMKeys)
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("error parsing pem_keys: %s", err)), nil
+	}
+	config.PublicKeys = publicKeys
+
+	entry, err := logical.StorageEntryJSON(configPath, config)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Put(ctx, entry); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func (b *kubeAuthBackend) pathConfigRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	b.l.RLock()
+	defer b.l.RUnlock()
+
+	config, err := b.loadConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"kubernetes_host":        config.Host,
+			"kubernetes_ca_cert":     config.CACert,
+			"pem_keys":               config.PEMKeys,
+			"issuer":                 config.Issuer,
+			"disable_iss_validation": config.DisableISSValidation,
+			"disable_local_ca_jwt":   config.DisableLocalCAJWT,
+			"use_jwks_discovery":     config.UseJWKSDiscovery,
+			"jwks_url":               config.JWKSURL,
+			"jwks_ca_cert":           config.JWKSCACert,
+			"jwks_refresh_interval":  int64(config.JWKSRefreshInterval.Seconds()),
+		},
+	}, nil
+}
+
+// parsePublicKeyPEMs decodes a list of PEM-encoded certificates or public
+// keys into *rsa.PublicKey / *ecdsa.PublicKey / ed25519.PublicKey values.
+func parsePublicKeyPEMs(pems []string) ([]interface{}, error) {
+	keys := make([]interface{}, 0, len(pems))
+	for _, p := range pems {
+		key, err := parsePublicKeyPEM([]byte(p))
+		if err != nil {
+			return nil, err
+		}
+		switch key.(type) {
+		case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+			keys = append(keys, key)
+		default:
+			return nil, fmt.Errorf("unsupported public key type %T", key)
+		}
+	}
+	return keys, nil
+}
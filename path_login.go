@@ -3,20 +3,19 @@ package kubeauth
 import (
 	"context"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rsa"
 	"errors"
 	"fmt"
 
-	"github.com/briankassouf/jose/crypto"
-	"github.com/briankassouf/jose/jws"
-	"github.com/briankassouf/jose/jwt"
 	"github.com/hashicorp/errwrap"
 	multierror "github.com/hashicorp/go-multierror"
 	"github.com/hashicorp/go-secure-stdlib/strutil"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/helper/cidrutil"
 	"github.com/hashicorp/vault/sdk/logical"
-	"github.com/mitchellh/mapstructure"
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
 )
 
 var (
@@ -92,7 +91,7 @@ func (b *kubeAuthBackend) pathLogin(ctx context.Context, req *logical.Request, d
 		return nil, err
 	}
 
-	serviceAccount, err := b.parseAndValidateJWT(jwtStr, role, config)
+	serviceAccount, err := b.parseAndValidateJWT(ctx, jwtStr, role, config)
 	if err != nil {
 		return nil, err
 	}
@@ -109,30 +108,48 @@ func (b *kubeAuthBackend) pathLogin(ctx context.Context, req *logical.Request, d
 		return nil, logical.ErrPermissionDenied
 	}
 
+	// annotation-driven alias names and bindings require fetching the
+	// ServiceAccount object itself, since neither the JWT claims nor the
+	// TokenReview response carry its annotations.
+	if role.AliasNameSource == aliasNameSourceSAAnnotation || len(role.BoundSAAnnotations) > 0 {
+		aliasName, err = b.applySAAnnotations(ctx, config, role, serviceAccount, aliasName)
+		if err != nil {
+			b.Logger().Error(`login unauthorized due to: ` + err.Error())
+			return nil, logical.ErrPermissionDenied
+		}
+	}
+
 	uid, err := serviceAccount.uid()
 	if err != nil {
 		return nil, err
 	}
+	aliasMetadata := map[string]string{
+		"service_account_uid":         uid,
+		"service_account_name":        serviceAccount.name(),
+		"service_account_namespace":   serviceAccount.namespace(),
+		"service_account_secret_name": serviceAccount.SecretName,
+	}
+	if serviceAccount.Kubernetes != nil && serviceAccount.Kubernetes.Pod != nil {
+		aliasMetadata["service_account_pod_name"] = serviceAccount.Kubernetes.Pod.Name
+		aliasMetadata["service_account_pod_uid"] = serviceAccount.Kubernetes.Pod.UID
+	}
+
+	metadata := map[string]string{
+		"role": roleName,
+	}
+	for k, v := range aliasMetadata {
+		metadata[k] = v
+	}
+
 	auth := &logical.Auth{
 		Alias: &logical.Alias{
-			Name: aliasName,
-			Metadata: map[string]string{
-				"service_account_uid":         uid,
-				"service_account_name":        serviceAccount.name(),
-				"service_account_namespace":   serviceAccount.namespace(),
-				"service_account_secret_name": serviceAccount.SecretName,
-			},
+			Name:     aliasName,
+			Metadata: aliasMetadata,
 		},
 		InternalData: map[string]interface{}{
 			"role": roleName,
 		},
-		Metadata: map[string]string{
-			"service_account_uid":         uid,
-			"service_account_name":        serviceAccount.name(),
-			"service_account_namespace":   serviceAccount.namespace(),
-			"service_account_secret_name": serviceAccount.SecretName,
-			"role":                        roleName,
-		},
+		Metadata:    metadata,
 		DisplayName: fmt.Sprintf("%s-%s", serviceAccount.namespace(), serviceAccount.name()),
 	}
 
@@ -161,11 +178,48 @@ func (b *kubeAuthBackend) getAliasName(role *roleStorageEntry, serviceAccount *s
 		return uid, nil
 	case aliasNameSourceSAName:
 		return fmt.Sprintf("%s/%s", serviceAccount.Namespace, serviceAccount.Name), nil
+	case aliasNameSourcePod:
+		if serviceAccount.Kubernetes == nil || serviceAccount.Kubernetes.Pod == nil {
+			return "", errors.New("alias_name_source is set to serviceaccount_pod, but JWT is not a projected service account token with pod info")
+		}
+		return fmt.Sprintf("%s/%s", serviceAccount.namespace(), serviceAccount.Kubernetes.Pod.Name), nil
+	case aliasNameSourceSAAnnotation:
+		// the actual annotation value is only available after fetching the
+		// ServiceAccount object, so it's filled in by applySAAnnotations.
+		return "", nil
 	default:
 		return "", fmt.Errorf("unknown alias_name_source %q", role.AliasNameSource)
 	}
 }
 
+// applySAAnnotations fetches the underlying ServiceAccount's annotations,
+// enforces role.BoundSAAnnotations against them, and returns the alias name
+// to use, overriding aliasName with the configured annotation's value when
+// role.AliasNameSource is aliasNameSourceSAAnnotation.
+func (b *kubeAuthBackend) applySAAnnotations(ctx context.Context, config *kubeConfig, role *roleStorageEntry, sa *serviceAccount, aliasName string) (string, error) {
+	annotations, err := b.serviceAccountAnnotations(ctx, config, sa.namespace(), sa.name())
+	if err != nil {
+		return "", err
+	}
+
+	for key, glob := range role.BoundSAAnnotations {
+		value, ok := annotations[key]
+		if !ok || !strutil.StrListContainsGlob([]string{glob}, value) {
+			return "", fmt.Errorf("service account annotation %q did not match", key)
+		}
+	}
+
+	if role.AliasNameSource == aliasNameSourceSAAnnotation {
+		value, ok := annotations[role.AliasNameAnnotation]
+		if !ok || value == "" {
+			return "", fmt.Errorf("service account is missing annotation %q required by alias_name_source", role.AliasNameAnnotation)
+		}
+		return value, nil
+	}
+
+	return aliasName, nil
+}
+
 // aliasLookahead returns the alias object with the SA UID from the JWT
 // Claims.
 // Only JWTs matching the specified role's configuration will be accepted as valid.
@@ -195,7 +249,7 @@ func (b *kubeAuthBackend) aliasLookahead(ctx context.Context, req *logical.Reque
 
 	// validation of the JWT against the provided role ensures alias look ahead requests
 	// are authentic.
-	sa, err := b.parseAndValidateJWT(jwtStr, role, config)
+	sa, err := b.parseAndValidateJWT(ctx, jwtStr, role, config)
 	if err != nil {
 		return nil, err
 	}
@@ -205,6 +259,13 @@ func (b *kubeAuthBackend) aliasLookahead(ctx context.Context, req *logical.Reque
 		return nil, err
 	}
 
+	if role.AliasNameSource == aliasNameSourceSAAnnotation || len(role.BoundSAAnnotations) > 0 {
+		aliasName, err = b.applySAAnnotations(ctx, config, role, sa, aliasName)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return &logical.Response{
 		Auth: &logical.Auth{
 			Alias: &logical.Alias{
@@ -215,103 +276,110 @@ func (b *kubeAuthBackend) aliasLookahead(ctx context.Context, req *logical.Reque
 }
 
 // parseAndValidateJWT is used to parse, validate and lookup the JWT token.
-func (b *kubeAuthBackend) parseAndValidateJWT(jwtStr string, role *roleStorageEntry, config *kubeConfig) (*serviceAccount, error) {
+func (b *kubeAuthBackend) parseAndValidateJWT(ctx context.Context, jwtStr string, role *roleStorageEntry, config *kubeConfig) (*serviceAccount, error) {
 	// Parse into JWT
-	parsedJWT, err := jws.ParseJWT([]byte(jwtStr))
+	parsedJWT, err := jwt.ParseSigned(jwtStr)
 	if err != nil {
 		return nil, err
 	}
 
 	sa := &serviceAccount{}
+	var claims jwt.Claims
 
-	validator := &jwt.Validator{
-		Fn: func(c jwt.Claims) error {
-			// Decode claims into a service account object
-			err := mapstructure.Decode(c, sa)
-			if err != nil {
-				return err
-			}
-
-			// verify the namespace is allowed
-			if len(role.ServiceAccountNamespaces) > 1 || role.ServiceAccountNamespaces[0] != "*" {
-				if !strutil.StrListContainsGlob(role.ServiceAccountNamespaces, sa.namespace()) {
-					return errors.New("namespace not authorized")
-				}
-			}
-
-			// verify the service account name is allowed
-			if len(role.ServiceAccountNames) > 1 || role.ServiceAccountNames[0] != "*" {
-				if !strutil.StrListContainsGlob(role.ServiceAccountNames, sa.name()) {
-					return errors.New("service account name not authorized")
-				}
-			}
+	// Claims can be read from the token without verifying the signature;
+	// signature verification happens per-candidate-key below. This mirrors
+	// the pre-go-jose behavior, where claim checks ran once up front and
+	// signing keys were only consulted to verify the signature.
+	if err := parsedJWT.UnsafeClaimsWithoutVerification(&claims, sa); err != nil {
+		return nil, err
+	}
 
-			return nil
-		},
+	if err := validateServiceAccountClaims(role, sa); err != nil {
+		return nil, err
 	}
 
+	expected := jwt.Expected{}
+
 	// perform ISS Claim validation if configured
 	if !config.DisableISSValidation {
-		// set the expected issuer to the default kubernetes issuer if the config doesn't specify it
-		if config.Issuer != "" {
-			validator.SetIssuer(config.Issuer)
-		} else {
-			validator.SetIssuer(defaultJWTIssuer)
+		// a role-bound issuer overrides the backend's configured issuer, so
+		// that a single mount can federate multiple clusters (one role per
+		// cluster); otherwise fall back to the configured or default
+		// kubernetes issuer.
+		switch {
+		case role.BoundIssuer != "":
+			expected.Issuer = role.BoundIssuer
+		case config.Issuer != "":
+			expected.Issuer = config.Issuer
+		default:
+			expected.Issuer = defaultJWTIssuer
 		}
 	}
 
 	// validate the audience if the role expects it
 	if role.Audience != "" {
-		validator.SetAudience(role.Audience)
+		expected.Audience = jwt.Audience{role.Audience}
 	}
 
-	if err := validator.Validate(parsedJWT); err != nil {
+	if err := claims.Validate(expected); err != nil {
 		return nil, err
 	}
 
+	// Determine the set of public keys to verify against. A role-bound key
+	// set overrides the backend's configured keys, for federating multiple
+	// clusters under one mount; otherwise fall back to the statically
+	// configured list, or the cluster's JWKS if discovery is enabled. JWKS
+	// is refreshed automatically on a kid-miss, so signing key rotation
+	// doesn't require an operator to update pem_keys.
+	publicKeys := config.PublicKeys
+	if len(role.PublicKeys) > 0 {
+		publicKeys = role.PublicKeys
+	} else if config.UseJWKSDiscovery {
+		kid, err := jwtKid(jwtStr)
+		if err != nil {
+			return nil, err
+		}
+
+		publicKeys, err = b.jwksPublicKeys(ctx, config, kid)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// If we don't have any public keys to verify, return the sa and end early.
-	if len(config.PublicKeys) == 0 {
+	if len(publicKeys) == 0 {
 		return sa, nil
 	}
 
 	// verifyFunc is called for each certificate that is configured in the
 	// backend until one of the certificates succeeds.
 	verifyFunc := func(cert interface{}) error {
-		// Parse Headers and verify the signing method matches the public key type
-		// configured. This is done in its own scope since we don't need most of
-		// these variables later.
-		var signingMethod crypto.SigningMethod
-		{
-			parsedJWS, err := jws.Parse([]byte(jwtStr))
-			if err != nil {
-				return err
-			}
-			headers := parsedJWS.Protected()
+		// Verify the signing method matches the public key type configured,
+		// so that e.g. an RSA key is never asked to verify an ES256 token.
+		if len(parsedJWT.Headers) == 0 {
+			return errors.New("provided JWT must have a header")
+		}
 
-			var algStr string
-			if headers.Has("alg") {
-				algStr = headers.Get("alg").(string)
-			} else {
-				return errors.New("provided JWT must have 'alg' header value")
+		switch jose.SignatureAlgorithm(parsedJWT.Headers[0].Algorithm) {
+		case jose.RS256, jose.RS384, jose.RS512:
+			if _, ok := cert.(*rsa.PublicKey); !ok {
+				return errMismatchedSigningMethod
 			}
-
-			signingMethod = jws.GetSigningMethod(algStr)
-			switch signingMethod.(type) {
-			case *crypto.SigningMethodECDSA:
-				if _, ok := cert.(*ecdsa.PublicKey); !ok {
-					return errMismatchedSigningMethod
-				}
-			case *crypto.SigningMethodRSA:
-				if _, ok := cert.(*rsa.PublicKey); !ok {
-					return errMismatchedSigningMethod
-				}
-			default:
-				return errors.New("unsupported JWT signing method")
+		case jose.ES256, jose.ES384, jose.ES512:
+			if _, ok := cert.(*ecdsa.PublicKey); !ok {
+				return errMismatchedSigningMethod
+			}
+		case jose.EdDSA:
+			if _, ok := cert.(ed25519.PublicKey); !ok {
+				return errMismatchedSigningMethod
 			}
+		default:
+			return errors.New("unsupported JWT signing method")
 		}
 
-		// validates the signature and then runs the claim validation
-		if err := parsedJWT.Validate(cert, signingMethod); err != nil {
+		// validates the signature; claims were already decoded and
+		// validated above.
+		if err := parsedJWT.Claims(cert, &jwt.Claims{}); err != nil {
 			return err
 		}
 
@@ -320,12 +388,12 @@ func (b *kubeAuthBackend) parseAndValidateJWT(jwtStr string, role *roleStorageEn
 
 	var validationErr error
 	// for each configured certificate run the verifyFunc
-	for _, cert := range config.PublicKeys {
+	for _, cert := range publicKeys {
 		err := verifyFunc(cert)
-		switch err {
-		case nil:
+		switch {
+		case err == nil:
 			return sa, nil
-		case rsa.ErrVerification, crypto.ErrECDSAVerification, errMismatchedSigningMethod:
+		case errors.Is(err, errMismatchedSigningMethod), errors.Is(err, jose.ErrCryptoFailure):
 			// if the error is a failure to verify or a signing method mismatch
 			// continue onto the next cert, storing the error to be returned if
 			// this is the last cert.
@@ -339,21 +407,58 @@ func (b *kubeAuthBackend) parseAndValidateJWT(jwtStr string, role *roleStorageEn
 	return nil, validationErr
 }
 
+// validateServiceAccountClaims enforces the role's service account, namespace
+// and pod bindings against the decoded claims.
+func validateServiceAccountClaims(role *roleStorageEntry, sa *serviceAccount) error {
+	// verify the namespace is allowed
+	if len(role.ServiceAccountNamespaces) > 1 || role.ServiceAccountNamespaces[0] != "*" {
+		if !strutil.StrListContainsGlob(role.ServiceAccountNamespaces, sa.namespace()) {
+			return errors.New("namespace not authorized")
+		}
+	}
+
+	// verify the service account name is allowed
+	if len(role.ServiceAccountNames) > 1 || role.ServiceAccountNames[0] != "*" {
+		if !strutil.StrListContainsGlob(role.ServiceAccountNames, sa.name()) {
+			return errors.New("service account name not authorized")
+		}
+	}
+
+	// verify the pod binding, if the role configures one. This is
+	// only possible for projected service account tokens, which are
+	// the only ones that carry pod identity.
+	if len(role.BoundPodNames) > 0 || len(role.BoundPodNamespaces) > 0 {
+		if sa.Kubernetes == nil || sa.Kubernetes.Pod == nil {
+			return errors.New("bound_pod_names or bound_pod_namespaces configured on role, but JWT is not a projected service account token with pod info")
+		}
+
+		if len(role.BoundPodNames) > 0 && !strutil.StrListContainsGlob(role.BoundPodNames, sa.Kubernetes.Pod.Name) {
+			return errors.New("pod name not authorized")
+		}
+
+		if len(role.BoundPodNamespaces) > 0 && !strutil.StrListContainsGlob(role.BoundPodNamespaces, sa.namespace()) {
+			return errors.New("pod namespace not authorized")
+		}
+	}
+
+	return nil
+}
+
 // serviceAccount holds the metadata from the JWT token and is used to lookup
 // the JWT in the kubernetes API and compare the results.
 type serviceAccount struct {
-	Name       string   `mapstructure:"kubernetes.io/serviceaccount/service-account.name"`
-	UID        string   `mapstructure:"kubernetes.io/serviceaccount/service-account.uid"`
-	SecretName string   `mapstructure:"kubernetes.io/serviceaccount/secret.name"`
-	Namespace  string   `mapstructure:"kubernetes.io/serviceaccount/namespace"`
-	Audience   []string `mapstructure:"aud"`
+	Name       string   `json:"kubernetes.io/serviceaccount/service-account.name"`
+	UID        string   `json:"kubernetes.io/serviceaccount/service-account.uid"`
+	SecretName string   `json:"kubernetes.io/serviceaccount/secret.name"`
+	Namespace  string   `json:"kubernetes.io/serviceaccount/namespace"`
+	Audience   []string `json:"aud"`
 
 	// the JSON returned from reviewing a Projected Service account has a
 	// different structure, where the information is in a sub-structure instead of
 	// at the top level
-	Kubernetes *projectedServiceToken `mapstructure:"kubernetes.io"`
-	Expiration int64                  `mapstructure:"exp"`
-	IssuedAt   int64                  `mapstructure:"iat"`
+	Kubernetes *projectedServiceToken `json:"kubernetes.io"`
+	Expiration int64                  `json:"exp"`
+	IssuedAt   int64                  `json:"iat"`
 }
 
 // uid returns the UID for the service account, preferring the projected service
@@ -391,14 +496,14 @@ func (s *serviceAccount) namespace() string {
 }
 
 type projectedServiceToken struct {
-	Namespace      string        `mapstructure:"namespace"`
-	Pod            *k8sObjectRef `mapstructure:"pod"`
-	ServiceAccount *k8sObjectRef `mapstructure:"serviceaccount"`
+	Namespace      string        `json:"namespace"`
+	Pod            *k8sObjectRef `json:"pod"`
+	ServiceAccount *k8sObjectRef `json:"serviceaccount"`
 }
 
 type k8sObjectRef struct {
-	Name string `mapstructure:"name"`
-	UID  string `mapstructure:"uid"`
+	Name string `json:"name"`
+	UID  string `json:"uid"`
 }
 
 // lookup calls the TokenReview API in kubernetes to verify the token and secret
@@ -0,0 +1,302 @@
+package kubeauth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func projectedSA(namespace, podName, podUID string) *serviceAccount {
+	return &serviceAccount{
+		Kubernetes: &projectedServiceToken{
+			Namespace: namespace,
+			Pod:       &k8sObjectRef{Name: podName, UID: podUID},
+			ServiceAccount: &k8sObjectRef{
+				Name: "my-sa",
+				UID:  "sa-uid",
+			},
+		},
+	}
+}
+
+func TestValidateServiceAccountClaims_PodBinding(t *testing.T) {
+	baseRole := func() *roleStorageEntry {
+		return &roleStorageEntry{
+			ServiceAccountNames:      []string{"*"},
+			ServiceAccountNamespaces: []string{"*"},
+		}
+	}
+
+	t.Run("no pod binding configured, no pod info required", func(t *testing.T) {
+		role := baseRole()
+		sa := &serviceAccount{Name: "my-sa", Namespace: "default"}
+		if err := validateServiceAccountClaims(role, sa); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("pod name matches glob", func(t *testing.T) {
+		role := baseRole()
+		role.BoundPodNames = []string{"web-*"}
+		sa := projectedSA("default", "web-7f8b", "pod-uid")
+		if err := validateServiceAccountClaims(role, sa); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("pod name does not match glob", func(t *testing.T) {
+		role := baseRole()
+		role.BoundPodNames = []string{"web-*"}
+		sa := projectedSA("default", "worker-1", "pod-uid")
+		if err := validateServiceAccountClaims(role, sa); err == nil {
+			t.Fatal("expected error for non-matching pod name")
+		}
+	})
+
+	t.Run("pod namespace does not match glob", func(t *testing.T) {
+		role := baseRole()
+		role.BoundPodNamespaces = []string{"prod-*"}
+		sa := projectedSA("staging", "web-1", "pod-uid")
+		if err := validateServiceAccountClaims(role, sa); err == nil {
+			t.Fatal("expected error for non-matching pod namespace")
+		}
+	})
+
+	t.Run("pod binding configured but JWT carries no pod info", func(t *testing.T) {
+		role := baseRole()
+		role.BoundPodNames = []string{"web-*"}
+		sa := &serviceAccount{Name: "my-sa", Namespace: "default"}
+		if err := validateServiceAccountClaims(role, sa); err == nil {
+			t.Fatal("expected error when pod binding is configured without a projected token")
+		}
+	})
+}
+
+func TestGetAliasName_Pod(t *testing.T) {
+	b := &kubeAuthBackend{}
+	role := &roleStorageEntry{AliasNameSource: aliasNameSourcePod}
+
+	t.Run("projected token", func(t *testing.T) {
+		sa := projectedSA("default", "web-1", "pod-uid")
+		name, err := b.getAliasName(role, sa)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if name != "default/web-1" {
+			t.Fatalf("unexpected alias name: %q", name)
+		}
+	})
+
+	t.Run("legacy token without pod info", func(t *testing.T) {
+		sa := &serviceAccount{Name: "my-sa", Namespace: "default"}
+		if _, err := b.getAliasName(role, sa); err == nil {
+			t.Fatal("expected error for non-projected token")
+		}
+	})
+}
+
+// signTestJWT builds a service account JWT signed with an Ed25519 key, to
+// exercise the go-jose based verification path end to end.
+func signTestJWT(t *testing.T, priv ed25519.PrivateKey, claims map[string]interface{}) string {
+	t.Helper()
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.EdDSA, Key: priv}, nil)
+	if err != nil {
+		t.Fatalf("failed to build signer: %v", err)
+	}
+
+	raw, err := jwt.Signed(signer).Claims(claims).CompactSerialize()
+	if err != nil {
+		t.Fatalf("failed to sign JWT: %v", err)
+	}
+
+	return raw
+}
+
+func TestParseAndValidateJWT_Ed25519AndAudience(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	role := &roleStorageEntry{
+		ServiceAccountNames:      []string{"*"},
+		ServiceAccountNamespaces: []string{"*"},
+		Audience:                 "vault",
+		PublicKeys:               []interface{}{pub},
+	}
+	config := &kubeConfig{DisableISSValidation: true}
+	b := &kubeAuthBackend{}
+
+	t.Run("matching audience verifies", func(t *testing.T) {
+		raw := signTestJWT(t, priv, map[string]interface{}{
+			"kubernetes.io/serviceaccount/service-account.uid":  "sa-uid",
+			"kubernetes.io/serviceaccount/service-account.name": "my-sa",
+			"kubernetes.io/serviceaccount/namespace":            "default",
+			"aud":                                               []string{"vault"},
+		})
+
+		sa, err := b.parseAndValidateJWT(context.Background(), raw, role, config)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if sa.name() != "my-sa" {
+			t.Fatalf("unexpected service account name: %q", sa.name())
+		}
+	})
+
+	t.Run("mismatched audience is rejected", func(t *testing.T) {
+		raw := signTestJWT(t, priv, map[string]interface{}{
+			"kubernetes.io/serviceaccount/service-account.uid":  "sa-uid",
+			"kubernetes.io/serviceaccount/service-account.name": "my-sa",
+			"kubernetes.io/serviceaccount/namespace":            "default",
+			"aud":                                               []string{"not-vault"},
+		})
+
+		if _, err := b.parseAndValidateJWT(context.Background(), raw, role, config); err == nil {
+			t.Fatal("expected audience validation to fail")
+		}
+	})
+}
+
+// TestParseAndValidateJWT_RoleOverridesIssuerAndKeys verifies that a role's
+// BoundIssuer and PublicKeys take precedence over the backend config, so a
+// single mount can federate multiple clusters, each with its own issuer and
+// signing keys.
+func TestParseAndValidateJWT_RoleOverridesIssuerAndKeys(t *testing.T) {
+	clusterAPub, clusterAPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	clusterBPub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	role := &roleStorageEntry{
+		ServiceAccountNames:      []string{"*"},
+		ServiceAccountNamespaces: []string{"*"},
+		BoundIssuer:              "https://cluster-a.example.com",
+		PublicKeys:               []interface{}{clusterAPub},
+	}
+	// the backend config points at a different cluster's issuer/keys, which
+	// must be ignored in favor of the role's.
+	config := &kubeConfig{
+		Issuer:     "https://cluster-b.example.com",
+		PublicKeys: []interface{}{clusterBPub},
+	}
+	b := &kubeAuthBackend{}
+
+	raw := signTestJWT(t, clusterAPriv, map[string]interface{}{
+		"kubernetes.io/serviceaccount/service-account.uid":  "sa-uid",
+		"kubernetes.io/serviceaccount/service-account.name": "my-sa",
+		"kubernetes.io/serviceaccount/namespace":            "default",
+		"iss":                                               "https://cluster-a.example.com",
+	})
+
+	sa, err := b.parseAndValidateJWT(context.Background(), raw, role, config)
+	if err != nil {
+		t.Fatalf("unexpected error validating against role-bound issuer and keys: %v", err)
+	}
+	if sa.name() != "my-sa" {
+		t.Fatalf("unexpected service account name: %q", sa.name())
+	}
+}
+
+// fakeServiceAccountGetter returns a fixed set of annotations for any
+// ServiceAccount looked up, for testing applySAAnnotations without a real
+// Kubernetes API server.
+type fakeServiceAccountGetter struct {
+	annotations map[string]string
+}
+
+func (f *fakeServiceAccountGetter) Get(ctx context.Context, namespace, name string) (map[string]string, error) {
+	return f.annotations, nil
+}
+
+func backendWithSAAnnotations(annotations map[string]string) *kubeAuthBackend {
+	return &kubeAuthBackend{
+		saGetterFactory: func(config *kubeConfig) serviceAccountGetter {
+			return &fakeServiceAccountGetter{annotations: annotations}
+		},
+		saAnnotations: &saAnnotationsCache{entries: make(map[string]saAnnotationsCacheEntry)},
+	}
+}
+
+func TestApplySAAnnotations(t *testing.T) {
+	sa := &serviceAccount{Name: "my-sa", Namespace: "default"}
+	config := &kubeConfig{}
+
+	t.Run("alias name is derived from the configured annotation", func(t *testing.T) {
+		b := backendWithSAAnnotations(map[string]string{"vault.hashicorp.com/alias-name": "team-a-identity"})
+		role := &roleStorageEntry{
+			AliasNameSource:     aliasNameSourceSAAnnotation,
+			AliasNameAnnotation: "vault.hashicorp.com/alias-name",
+		}
+
+		name, err := b.applySAAnnotations(context.Background(), config, role, sa, "fallback")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if name != "team-a-identity" {
+			t.Fatalf("unexpected alias name: %q", name)
+		}
+	})
+
+	t.Run("missing configured annotation is an error", func(t *testing.T) {
+		b := backendWithSAAnnotations(map[string]string{})
+		role := &roleStorageEntry{
+			AliasNameSource:     aliasNameSourceSAAnnotation,
+			AliasNameAnnotation: "vault.hashicorp.com/alias-name",
+		}
+
+		if _, err := b.applySAAnnotations(context.Background(), config, role, sa, "fallback"); err == nil {
+			t.Fatal("expected error when the configured annotation is absent")
+		}
+	})
+
+	t.Run("bound annotation glob matches", func(t *testing.T) {
+		b := backendWithSAAnnotations(map[string]string{"team": "platform"})
+		role := &roleStorageEntry{
+			BoundSAAnnotations: map[string]string{"team": "platform*"},
+		}
+
+		name, err := b.applySAAnnotations(context.Background(), config, role, sa, "fallback")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if name != "fallback" {
+			t.Fatalf("unexpected alias name: %q", name)
+		}
+	})
+
+	// Regression test: a glob of "*" means "any value", but it must not be
+	// satisfied by an annotation that's entirely absent from the
+	// ServiceAccount - "*" commonly expresses "must have annotation X",
+	// not "X is optional".
+	t.Run("bound annotation missing entirely does not satisfy a wildcard glob", func(t *testing.T) {
+		b := backendWithSAAnnotations(map[string]string{})
+		role := &roleStorageEntry{
+			BoundSAAnnotations: map[string]string{"team": "*"},
+		}
+
+		if _, err := b.applySAAnnotations(context.Background(), config, role, sa, "fallback"); err == nil {
+			t.Fatal("expected error when a bound annotation is missing, even with a wildcard glob")
+		}
+	})
+
+	t.Run("bound annotation glob does not match", func(t *testing.T) {
+		b := backendWithSAAnnotations(map[string]string{"team": "platform"})
+		role := &roleStorageEntry{
+			BoundSAAnnotations: map[string]string{"team": "infra*"},
+		}
+
+		if _, err := b.applySAAnnotations(context.Background(), config, role, sa, "fallback"); err == nil {
+			t.Fatal("expected error for non-matching bound annotation")
+		}
+	})
+}
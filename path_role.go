@@ -0,0 +1,279 @@
+package kubeauth
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/tokenutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	aliasNameSourceUnset        = ""
+	aliasNameSourceSAUid        = "serviceaccount_uid"
+	aliasNameSourceSAName       = "serviceaccount_name"
+	aliasNameSourcePod          = "serviceaccount_pod"
+	aliasNameSourceSAAnnotation = "serviceaccount_annotation"
+)
+
+// roleStorageEntry stores the options that are set when a role is created.
+type roleStorageEntry struct {
+	tokenutil.TokenParams
+
+	// ServiceAccountNames is the array of service account names that
+	// authenticate to this role.
+	ServiceAccountNames []string `json:"bound_service_account_names"`
+
+	// ServiceAccountNamespaces is the array of namespaces that authenticate
+	// to this role.
+	ServiceAccountNamespaces []string `json:"bound_service_account_namespaces"`
+
+	// Audience is the required value of the `aud` claim.
+	Audience string `json:"audience"`
+
+	// BoundPodNames is the array of pod name globs that are allowed to
+	// authenticate to this role. Only projected service account tokens,
+	// which carry the pod's identity, can satisfy it; other tokens are
+	// rejected while a pod binding is set.
+	BoundPodNames []string `json:"bound_pod_names"`
+
+	// BoundPodNamespaces is the array of namespace globs that a bound pod
+	// must belong to. Like BoundPodNames, it rejects tokens that are not
+	// projected service account tokens.
+	BoundPodNamespaces []string `json:"bound_pod_namespaces"`
+
+	// AliasNameSource determines how the alias name is computed.
+	AliasNameSource string `json:"alias_name_source"`
+
+	// BoundIssuer, if set, overrides config.Issuer for logins against this
+	// role, so a single mount can federate multiple clusters, each with
+	// their own trusted issuer.
+	BoundIssuer string `json:"bound_issuer"`
+
+	// PEMKeys is the list of PEM-encoded certificates/public keys used to
+	// verify the signature on the service account JWTs for this role. If
+	// set, it overrides config.PublicKeys for logins against this role.
+	PEMKeys []string `json:"pem_keys"`
+
+	// PublicKeys is the parsed form of PEMKeys, containing *rsa.PublicKey,
+	// *ecdsa.PublicKey, or ed25519.PublicKey values.
+	PublicKeys []interface{} `json:"-"`
+
+	// AliasNameAnnotation is the key of an annotation on the underlying
+	// ServiceAccount object whose value becomes the alias name. Required
+	// when AliasNameSource is aliasNameSourceSAAnnotation.
+	AliasNameAnnotation string `json:"alias_name_annotation"`
+
+	// BoundSAAnnotations, if set, must all match (by glob) the underlying
+	// ServiceAccount's annotations for login to succeed.
+	BoundSAAnnotations map[string]string `json:"bound_service_account_annotations"`
+}
+
+func pathRole(b *kubeAuthBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "role/" + framework.GenericNameRegex("name"),
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of the role.",
+			},
+			"bound_service_account_names": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "List of service account names able to access this role. If set to \"*\" all names are allowed.",
+			},
+			"bound_service_account_namespaces": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "List of namespaces allowed to access this role. If set to \"*\" all namespaces are allowed.",
+			},
+			"audience": {
+				Type:        framework.TypeString,
+				Description: "Optional Audience claim to verify in the JWT.",
+			},
+			"bound_pod_names": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "List of pod name globs that are allowed to access this role. Only projected service account tokens, which carry the pod's identity, can satisfy it; other tokens are rejected when it is set.",
+			},
+			"bound_pod_namespaces": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "List of namespace globs that a bound pod must belong to. Tokens that are not projected service account tokens are rejected when it is set.",
+			},
+			"alias_name_source": {
+				Type:        framework.TypeString,
+				Default:     aliasNameSourceSAUid,
+				Description: "Source to use when deriving the Alias name. valid choices: \"serviceaccount_uid\", \"serviceaccount_name\", \"serviceaccount_pod\", or \"serviceaccount_annotation\"",
+			},
+			"alias_name_annotation": {
+				Type:        framework.TypeString,
+				Description: "Key of the annotation on the underlying ServiceAccount object whose value becomes the alias name. Required when alias_name_source is \"serviceaccount_annotation\".",
+			},
+			"bound_service_account_annotations": {
+				Type:        framework.TypeKVPairs,
+				Description: "Map of annotation key to glob value that must all match the underlying ServiceAccount's annotations for login to succeed.",
+			},
+			"bound_issuer": {
+				Type:        framework.TypeString,
+				Description: "Optional JWT issuer that overrides the backend's configured issuer for logins against this role, for federating multiple clusters under one mount.",
+			},
+			"pem_keys": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Optional list of PEM-formatted public keys or certificates used to verify the signatures of service account JWTs for this role, overriding the backend's configured pem_keys.",
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.CreateOperation: b.pathRoleCreateUpdate,
+			logical.UpdateOperation: b.pathRoleCreateUpdate,
+			logical.ReadOperation:   b.pathRoleRead,
+			logical.DeleteOperation: b.pathRoleDelete,
+		},
+
+		HelpSynopsis:    "Register a role with the backend.",
+		HelpDescription: "Register a role with the backend, binding it to service account names and namespaces.",
+	}
+}
+
+func pathRoleList(b *kubeAuthBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "role/?$",
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ListOperation: b.pathRoleList,
+		},
+
+		HelpSynopsis:    "List the existing roles in this backend.",
+		HelpDescription: "Roles will be listed by the role name.",
+	}
+}
+
+func (b *kubeAuthBackend) pathRoleCreateUpdate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := strings.ToLower(data.Get("name").(string))
+	if name == "" {
+		return logical.ErrorResponse("missing name"), nil
+	}
+
+	b.l.Lock()
+	defer b.l.Unlock()
+
+	role, err := b.role(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		role = &roleStorageEntry{}
+	}
+
+	if names, ok := data.GetOk("bound_service_account_names"); ok {
+		role.ServiceAccountNames = names.([]string)
+	}
+	if namespaces, ok := data.GetOk("bound_service_account_namespaces"); ok {
+		role.ServiceAccountNamespaces = namespaces.([]string)
+	}
+	if len(role.ServiceAccountNames) == 0 {
+		return logical.ErrorResponse("bound_service_account_names cannot be empty"), nil
+	}
+	if len(role.ServiceAccountNamespaces) == 0 {
+		return logical.ErrorResponse("bound_service_account_namespaces cannot be empty"), nil
+	}
+
+	if audience, ok := data.GetOk("audience"); ok {
+		role.Audience = audience.(string)
+	}
+	if podNames, ok := data.GetOk("bound_pod_names"); ok {
+		role.BoundPodNames = podNames.([]string)
+	}
+	if podNamespaces, ok := data.GetOk("bound_pod_namespaces"); ok {
+		role.BoundPodNamespaces = podNamespaces.([]string)
+	}
+	if source, ok := data.GetOk("alias_name_source"); ok {
+		role.AliasNameSource = source.(string)
+	}
+	if annotation, ok := data.GetOk("alias_name_annotation"); ok {
+		role.AliasNameAnnotation = annotation.(string)
+	}
+	switch role.AliasNameSource {
+	case aliasNameSourceUnset:
+		role.AliasNameSource = aliasNameSourceSAUid
+	case aliasNameSourceSAUid, aliasNameSourceSAName, aliasNameSourcePod:
+	case aliasNameSourceSAAnnotation:
+		if role.AliasNameAnnotation == "" {
+			return logical.ErrorResponse("alias_name_annotation is required when alias_name_source is \"serviceaccount_annotation\""), nil
+		}
+	default:
+		return logical.ErrorResponse(fmt.Sprintf("invalid alias_name_source %q", role.AliasNameSource)), nil
+	}
+
+	if annotations, ok := data.GetOk("bound_service_account_annotations"); ok {
+		role.BoundSAAnnotations = annotations.(map[string]string)
+	}
+
+	if issuer, ok := data.GetOk("bound_issuer"); ok {
+		role.BoundIssuer = issuer.(string)
+	}
+	if pemKeys, ok := data.GetOk("pem_keys"); ok {
+		role.PEMKeys = pemKeys.([]string)
+	}
+	publicKeys, err := parsePublicKeyPEMs(role.PEMKeys)
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("error parsing pem_keys: %s", err)), nil
+	}
+	role.PublicKeys = publicKeys
+
+	entry, err := logical.StorageEntryJSON(rolePrefix+name, role)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Put(ctx, entry); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func (b *kubeAuthBackend) pathRoleRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	b.l.RLock()
+	defer b.l.RUnlock()
+
+	role, err := b.role(ctx, req.Storage, data.Get("name").(string))
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return nil, nil
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"bound_service_account_names":       role.ServiceAccountNames,
+			"bound_service_account_namespaces":  role.ServiceAccountNamespaces,
+			"audience":                          role.Audience,
+			"bound_pod_names":                   role.BoundPodNames,
+			"bound_pod_namespaces":              role.BoundPodNamespaces,
+			"alias_name_source":                 role.AliasNameSource,
+			"bound_issuer":                      role.BoundIssuer,
+			"pem_keys":                          role.PEMKeys,
+			"alias_name_annotation":             role.AliasNameAnnotation,
+			"bound_service_account_annotations": role.BoundSAAnnotations,
+		},
+	}, nil
+}
+
+func (b *kubeAuthBackend) pathRoleDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	b.l.Lock()
+	defer b.l.Unlock()
+
+	err := req.Storage.Delete(ctx, rolePrefix+strings.ToLower(data.Get("name").(string)))
+	return nil, err
+}
+
+func (b *kubeAuthBackend) pathRoleList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	b.l.RLock()
+	defer b.l.RUnlock()
+
+	roles, err := req.Storage.List(ctx, rolePrefix)
+	if err != nil {
+		return nil, err
+	}
+	return logical.ListResponse(roles), nil
+}
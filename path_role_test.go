@@ -0,0 +1,149 @@
+package kubeauth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// testEd25519PEM returns a single PEM-encoded Ed25519 public key, suitable
+// for the pem_keys field.
+func testEd25519PEM(t *testing.T) []string {
+	t.Helper()
+
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	return []string{string(marshalPublicKeyPEM(t, pub))}
+}
+
+func TestPathRoleCreateUpdate_BoundIssuerAndPEMKeys(t *testing.T) {
+	b := &kubeAuthBackend{}
+	storage := &logical.InmemStorage{}
+
+	fd := framework.FieldData{
+		Raw: map[string]interface{}{
+			"name":                             "my-role",
+			"bound_service_account_names":      "my-sa",
+			"bound_service_account_namespaces": "default",
+			"bound_issuer":                     "https://cluster-a.example.com",
+			"pem_keys":                         testEd25519PEM(t),
+		},
+		Schema: pathRole(b).Fields,
+	}
+
+	req := &logical.Request{Storage: storage}
+	if resp, err := b.pathRoleCreateUpdate(context.Background(), req, &fd); err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("unexpected error creating role: err=%v resp=%v", err, resp)
+	}
+
+	role, err := b.role(context.Background(), storage, "my-role")
+	if err != nil {
+		t.Fatalf("unexpected error loading role: %v", err)
+	}
+	if role == nil {
+		t.Fatal("expected role to be persisted")
+	}
+	if role.BoundIssuer != "https://cluster-a.example.com" {
+		t.Fatalf("unexpected bound issuer: %q", role.BoundIssuer)
+	}
+	if len(role.PublicKeys) != 1 {
+		t.Fatalf("expected 1 parsed public key, got %d", len(role.PublicKeys))
+	}
+}
+
+// TestPathRoleCreateUpdate_MixedCaseName is a regression test: role names
+// are read back via a lowercased storage key (b.role), so a name containing
+// uppercase letters must be written and deleted under that same lowercased
+// key, or it becomes permanently unreadable - and a subsequent "update"
+// would be silently treated as a brand new role, discarding any fields not
+// resent in that update.
+func TestPathRoleCreateUpdate_MixedCaseName(t *testing.T) {
+	b := &kubeAuthBackend{}
+	storage := &logical.InmemStorage{}
+	schema := pathRole(b).Fields
+
+	create := &framework.FieldData{
+		Raw: map[string]interface{}{
+			"name":                             "MyRole",
+			"bound_service_account_names":      "my-sa",
+			"bound_service_account_namespaces": "default",
+			"bound_issuer":                     "https://cluster-a.example.com",
+		},
+		Schema: schema,
+	}
+	req := &logical.Request{Storage: storage}
+	if resp, err := b.pathRoleCreateUpdate(context.Background(), req, create); err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("unexpected error creating role: err=%v resp=%v", err, resp)
+	}
+
+	role, err := b.role(context.Background(), storage, "MyRole")
+	if err != nil {
+		t.Fatalf("unexpected error loading role: %v", err)
+	}
+	if role == nil {
+		t.Fatal("expected role with a mixed-case name to be readable back")
+	}
+
+	// An update that only resends one field must not silently create a new
+	// role and drop BoundIssuer.
+	update := &framework.FieldData{
+		Raw: map[string]interface{}{
+			"name":                             "MyRole",
+			"bound_service_account_names":      "my-sa",
+			"bound_service_account_namespaces": "default",
+			"audience":                         "vault",
+		},
+		Schema: schema,
+	}
+	if resp, err := b.pathRoleCreateUpdate(context.Background(), req, update); err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("unexpected error updating role: err=%v resp=%v", err, resp)
+	}
+
+	role, err = b.role(context.Background(), storage, "MyRole")
+	if err != nil {
+		t.Fatalf("unexpected error loading role after update: %v", err)
+	}
+	if role == nil {
+		t.Fatal("expected role to still exist after update")
+	}
+	if role.BoundIssuer != "https://cluster-a.example.com" {
+		t.Fatalf("update silently discarded BoundIssuer, got %q", role.BoundIssuer)
+	}
+	if role.Audience != "vault" {
+		t.Fatalf("unexpected audience after update: %q", role.Audience)
+	}
+
+	read := &framework.FieldData{
+		Raw:    map[string]interface{}{"name": "MyRole"},
+		Schema: schema,
+	}
+	resp, err := b.pathRoleRead(context.Background(), req, read)
+	if err != nil {
+		t.Fatalf("unexpected error reading role: %v", err)
+	}
+	if resp == nil {
+		t.Fatal("expected pathRoleRead to find the mixed-case role")
+	}
+
+	del := &framework.FieldData{
+		Raw:    map[string]interface{}{"name": "MyRole"},
+		Schema: schema,
+	}
+	if _, err := b.pathRoleDelete(context.Background(), req, del); err != nil {
+		t.Fatalf("unexpected error deleting role: %v", err)
+	}
+	role, err = b.role(context.Background(), storage, "MyRole")
+	if err != nil {
+		t.Fatalf("unexpected error loading role after delete: %v", err)
+	}
+	if role != nil {
+		t.Fatal("expected role to be gone after delete")
+	}
+}